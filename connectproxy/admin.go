@@ -0,0 +1,128 @@
+package connectproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// hostStatus is one entry of the admin /status response, describing a single proxied
+// host's current target, cert and traffic.
+type hostStatus struct {
+	LocalTarget     string    `json:"local_target"`
+	CertFingerprint string    `json:"cert_fingerprint_sha256"`
+	CertNotAfter    time.Time `json:"cert_not_after"`
+	RequestCount    uint64    `json:"request_count"`
+}
+
+// AdminHandler returns a handler serving the same admin endpoints as AdminHost, for
+// callers that want to run them on a separate listener (e.g. --admin_addr) instead of
+// matching on the Host header of the main CONNECT listener.
+func (p *Proxy) AdminHandler() http.Handler {
+	return http.HandlerFunc(p.serveAdmin)
+}
+
+// serveAdmin handles the admin endpoints exposed on AdminHost (or a separate
+// --admin_addr listener): serving the CA certificate so clients can install it as a
+// trust root, reporting live diagnostics, and flushing the per-host cert/proxy cache.
+func (p *Proxy) serveAdmin(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/ca.crt":
+		p.serveCACert(w, req)
+	case "/ca.der":
+		p.serveCACertDER(w, req)
+	case "/status":
+		p.serveStatus(w, req)
+	case "/flush":
+		p.serveFlush(w, req)
+	case "/tofu/reset":
+		p.serveTOFUReset(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (p *Proxy) serveCACert(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Write(p.FakeCA.CertPEM)
+}
+
+func (p *Proxy) serveCACertDER(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	block, _ := pem.Decode(p.FakeCA.CertPEM)
+	if block == nil {
+		http.Error(w, "failed to decode CA certificate", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Write(block.Bytes)
+}
+
+func (p *Proxy) serveStatus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.proxyMutex.RLock()
+	status := make(map[string]hostStatus, len(p.ProxyMap))
+	for localHost, info := range p.ProxyMap {
+		fingerprint := sha256.Sum256(info.cert.Raw)
+		status[localHost] = hostStatus{
+			LocalTarget:     info.proxyHost,
+			CertFingerprint: hex.EncodeToString(fingerprint[:]),
+			CertNotAfter:    info.cert.NotAfter,
+			RequestCount:    atomic.LoadUint64(&info.requestCount),
+		}
+	}
+	p.proxyMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (p *Proxy) serveFlush(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveTOFUReset drops a single upstream TOFU pin (form value "host", a "host:port"),
+// so the next connection to it re-pins on first use instead of being rejected as a
+// mismatch.
+func (p *Proxy) serveTOFUReset(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p.tofuStore == nil {
+		http.Error(w, "TOFU pinning is not enabled (-tofu_store)", http.StatusNotFound)
+		return
+	}
+
+	host := req.FormValue("host")
+	if host == "" {
+		http.Error(w, "missing host parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.tofuStore.Reset(host); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,6 +4,7 @@ package connectproxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"log"
@@ -11,21 +12,124 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/thinkassembly/flutterproxy/fakeca"
 	"github.com/thinkassembly/flutterproxy/httpsproxy"
+	"github.com/thinkassembly/flutterproxy/proxyproto"
+	"github.com/thinkassembly/flutterproxy/tofu"
+	"github.com/thinkassembly/flutterproxy/transcoder"
+	"github.com/thinkassembly/flutterproxy/transcript"
 )
 
+// Option configures optional behavior of a Proxy returned by New.
+type Option func(*options)
+
+type options struct {
+	certConfig      fakeca.CertConfig
+	adminHost       string
+	recorder        transcript.Recorder
+	maxBodyBytes    int64
+	proxyProtoPairs []string
+	transcoders     map[string]transcoder.Transcoder
+	tofuStore       *tofu.Store
+}
+
+// WithTOFUStore pins the upstream TLS certificate presented to every per-host
+// httpsproxy.Proxy's "next" (real-server-forwarding) connection to its
+// SubjectPublicKeyInfo hash on first use, aborting the connection if it later changes.
+func WithTOFUStore(store *tofu.Store) Option {
+	return func(o *options) { o.tofuStore = store }
+}
+
+// WithTranscoder registers t to rewrite intercepted responses whose Content-Encoding or
+// Content-Type matches mime (e.g. "gzip", "application/json"). Repeated calls with the
+// same mime replace the previous registration.
+func WithTranscoder(mime string, t transcoder.Transcoder) Option {
+	return func(o *options) {
+		if o.transcoders == nil {
+			o.transcoders = make(map[string]transcoder.Transcoder)
+		}
+		o.transcoders[mime] = t
+	}
+}
+
+// WithProxyProtocol writes a HAProxy PROXY protocol header on the outbound tunnel for
+// hosts matched by pairs, each formatted "<host>,<version>" (e.g.
+// "yoursite.com:443,v2"), where host is the same "host:port" used in a --host_pair.
+func WithProxyProtocol(pairs []string) Option {
+	return func(o *options) { o.proxyProtoPairs = pairs }
+}
+
+// WithRecorder records every intercepted HTTPS exchange to recorder (see the
+// transcript package for HAR and JSONL implementations).
+func WithRecorder(recorder transcript.Recorder) Option {
+	return func(o *options) { o.recorder = recorder }
+}
+
+// WithRecordBodyCap bounds how many bytes of a request/response body are kept in a
+// recorded transcript entry.
+func WithRecordBodyCap(maxBytes int64) Option {
+	return func(o *options) { o.maxBodyBytes = maxBytes }
+}
+
+// WithAdminHost serves the admin endpoints (see admin.go) on the main CONNECT listener
+// whenever an incoming request's Host header matches host.
+func WithAdminHost(host string) Option {
+	return func(o *options) { o.adminHost = host }
+}
+
+// WithCertCacheSize bounds the number of distinct per-host leaf certs the proxy caches.
+func WithCertCacheSize(n int) Option {
+	return func(o *options) { o.certConfig.CacheSize = n }
+}
+
+// WithCertTTL sets how long a cached leaf cert is reused before being reissued.
+func WithCertTTL(ttl time.Duration) Option {
+	return func(o *options) { o.certConfig.TTL = ttl }
+}
+
+// WithCertKeyType selects the key algorithm used for leaf certs (RSA or ECDSA).
+func WithCertKeyType(kt fakeca.KeyType) Option {
+	return func(o *options) { o.certConfig.KeyType = kt }
+}
+
+// WithCertValidity sets the NotBefore..NotAfter window stamped on issued leaf certs.
+func WithCertValidity(validity time.Duration) Option {
+	return func(o *options) { o.certConfig.Validity = validity }
+}
+
 // New returns a HTTP CONNECT proxy server.
-func New(fakeCA *fakeca.FakeCA, hostPairs []string, prefixPairs []string, done chan<- bool) *Proxy {
+func New(fakeCA *fakeca.FakeCA, hostPairs []string, prefixPairs []string, done chan<- bool, opts ...Option) *Proxy {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	certStore, err := fakeca.NewCertStore(fakeCA, o.certConfig)
+	if err != nil {
+		// NewCertStore fails only when marshaling the CA's own public key or generating
+		// the shared leaf key fails, which New's callers have no recourse to recover
+		// from; fail loudly like hijack failures elsewhere in this package.
+		log.Fatalf("create cert store: %v", err)
+	}
+
 	p := &Proxy{
 		// redirectAddr: redirectAddr,
-		FakeCA:    fakeCA,
-		LocalMap:  make(map[string]string),
-		RemoteMap: make(map[string]string),
-		ProxyMap:  make(map[string]*domainInfo),
-		PrefixMap: make(map[string][]string),
-		done:      done,
+		FakeCA:        fakeCA,
+		CertStore:     certStore,
+		AdminHost:     o.adminHost,
+		Recorder:      o.recorder,
+		maxBodyBytes:  o.maxBodyBytes,
+		transcoders:   o.transcoders,
+		tofuStore:     o.tofuStore,
+		LocalMap:      make(map[string]string),
+		RemoteMap:     make(map[string]string),
+		ProxyMap:      make(map[string]*domainInfo),
+		PrefixMap:     make(map[string][]string),
+		ProxyProtoMap: make(map[string]string),
+		done:          done,
 	}
 
 	for _, v := range hostPairs {
@@ -44,6 +148,13 @@ func New(fakeCA *fakeca.FakeCA, hostPairs []string, prefixPairs []string, done c
 		}
 	}
 
+	for _, v := range o.proxyProtoPairs {
+		parts := strings.Split(v, ",")
+		if len(parts) == 2 {
+			p.ProxyProtoMap[parts[0]] = parts[1]
+		}
+	}
+
 	return p
 }
 
@@ -51,12 +162,27 @@ type domainInfo struct {
 	proxyHost  string
 	certPEM    []byte
 	privKeyPEM []byte
+	cert       *x509.Certificate
 	httpsProxy *httpsproxy.Proxy
+
+	requestCount uint64 // accessed atomically
 }
 
 // Proxy is a HTTP CONNECT proxy server.
 type Proxy struct {
-	FakeCA *fakeca.FakeCA
+	FakeCA    *fakeca.FakeCA
+	CertStore *fakeca.CertStore
+
+	// AdminHost, if non-empty, is the Host header that routes a request to the admin
+	// endpoints (see admin.go) instead of being proxied.
+	AdminHost string
+
+	// Recorder, if set, receives a transcript.Entry for every intercepted HTTPS
+	// exchange across every per-host httpsproxy.Proxy this Proxy starts.
+	Recorder     transcript.Recorder
+	maxBodyBytes int64
+	transcoders  map[string]transcoder.Transcoder
+	tofuStore    *tofu.Store
 
 	redirectAddr string
 
@@ -64,6 +190,10 @@ type Proxy struct {
 	RemoteMap map[string]string
 	PrefixMap map[string][]string
 
+	// ProxyProtoMap maps a "host:port" (as used in a --host_pair) to the PROXY
+	// protocol version ("v1" or "v2") to write on the outbound tunnel to it.
+	ProxyProtoMap map[string]string
+
 	ProxyMap   map[string]*domainInfo
 	proxyMutex sync.RWMutex
 
@@ -80,6 +210,10 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		p.done <- true
 		return
 	}
+	if p.AdminHost != "" && hostKey(req.Host) == hostKey(p.AdminHost) {
+		p.serveAdmin(w, req)
+		return
+	}
 	if req.Method != http.MethodConnect {
 		p.redirectToHTTPS(w, req)
 		return
@@ -114,6 +248,7 @@ func (p *Proxy) maybeStartHTTPSProxy(w http.ResponseWriter, req *http.Request) (
 	proxyInfo, ok := p.ProxyMap[localHost]
 	p.proxyMutex.RUnlock()
 	if ok {
+		atomic.AddUint64(&proxyInfo.requestCount, 1)
 		return proxyInfo.proxyHost, nil
 	}
 
@@ -122,11 +257,12 @@ func (p *Proxy) maybeStartHTTPSProxy(w http.ResponseWriter, req *http.Request) (
 
 	proxyInfo, ok = p.ProxyMap[localHost]
 	if ok {
+		atomic.AddUint64(&proxyInfo.requestCount, 1)
 		return proxyInfo.proxyHost, nil
 	}
 
 	host := req.URL.Hostname()
-	certPEM, privKeyPEM, err := p.FakeCA.NewCert([]string{host})
+	certPEM, privKeyPEM, err := p.CertStore.Cert([]string{host})
 	if err != nil {
 		return "", err
 	}
@@ -137,7 +273,12 @@ func (p *Proxy) maybeStartHTTPSProxy(w http.ResponseWriter, req *http.Request) (
 		return "", err
 	}
 
-	httpsProxy, err := httpsproxy.New(serverCert, localHost, req.Host, p.PrefixMap[req.Host])
+	cert, err := x509.ParseCertificate(serverCert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+
+	httpsProxy, err := httpsproxy.New(serverCert, localHost, req.Host, p.PrefixMap[req.Host], p.Recorder, p.maxBodyBytes, p.transcoders, p.tofuStore, p.ProxyProtoMap[req.Host])
 	if err != nil {
 		return "", err
 	}
@@ -148,15 +289,29 @@ func (p *Proxy) maybeStartHTTPSProxy(w http.ResponseWriter, req *http.Request) (
 	}
 
 	p.ProxyMap[localHost] = &domainInfo{
-		proxyHost:  addr,
-		certPEM:    certPEM,
-		privKeyPEM: privKeyPEM,
-		httpsProxy: httpsProxy,
+		proxyHost:    addr,
+		certPEM:      certPEM,
+		privKeyPEM:   privKeyPEM,
+		cert:         cert,
+		httpsProxy:   httpsProxy,
+		requestCount: 1,
 	}
 
 	return addr, nil
 }
 
+// Flush drops every cached per-host proxy and leaf certificate. The next CONNECT to
+// each host starts a fresh httpsproxy.Proxy with a freshly issued certificate. Flush
+// does not reload the CA: new certificates are still signed by the CA that CertStore
+// was created with.
+func (p *Proxy) Flush() {
+	p.proxyMutex.Lock()
+	p.ProxyMap = make(map[string]*domainInfo)
+	p.proxyMutex.Unlock()
+
+	p.CertStore.Flush()
+}
+
 func (p *Proxy) handleConnect(w http.ResponseWriter, req *http.Request) {
 	log.Printf("%-8s PROXY    %s : %s", req.Method, req.Host, req.URL.String())
 
@@ -180,6 +335,20 @@ func (p *Proxy) handleConnect(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if version, ok := p.ProxyProtoMap[req.Host]; ok {
+		// When proxyHost is set this writes to the local httpsproxy listener, not the
+		// real backend: it relays the CONNECT client's address inward so the per-host
+		// httpsproxy.Proxy (started with the same version, see maybeStartHTTPSProxy)
+		// can re-emit it on its own dial to the real upstream. When proxyHost is unset
+		// this is a direct passthrough tunnel, and targetConn already is that backend.
+		if err := proxyproto.WriteHeader(targetConn, version, req.RemoteAddr, targetConn.RemoteAddr().String()); err != nil {
+			log.Printf("%-8s PROXYPROTO write header to %s: %v", req.Method, remoteHost, err)
+			targetConn.Close()
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusOK)
 	hj, ok := w.(http.Hijacker)
 	if !ok {
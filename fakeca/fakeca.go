@@ -3,14 +3,23 @@ package fakeca
 
 import (
 	"bytes"
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"math/big"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -112,6 +121,10 @@ func NewCA() (*FakeCA, error) {
 }
 
 // NewCert creates a fake certificate for the given domain names.
+//
+// Deprecated: NewCert generates a fresh RSA-4096 keypair on every call, which takes
+// multiple seconds and is too slow to sit on the CONNECT hot path. Use NewCertStore
+// instead, which reuses a single leaf key and caches issued certificates.
 func (ca *FakeCA) NewCert(dnsNames []string) (certPEM, privKeyPEM []byte, err2 error) {
 	cert := &x509.Certificate{
 		SerialNumber: big.NewInt(1658),
@@ -151,3 +164,277 @@ func (ca *FakeCA) NewCert(dnsNames []string) (certPEM, privKeyPEM []byte, err2 e
 
 	return certBuf.Bytes(), keyBuf.Bytes(), nil
 }
+
+// KeyType selects the key algorithm used for leaf certificates issued by a CertStore.
+type KeyType int
+
+const (
+	// ECDSA issues P-256 leaf certificates, generating a single leaf key at startup and
+	// reusing it across every cert the store issues after that. This is the default: it
+	// is what makes on-the-fly issuance fast enough to sit on the CONNECT hot path.
+	ECDSA KeyType = iota
+	// RSA issues a fresh RSA-4096 leaf keypair per certificate, matching the behavior of
+	// the deprecated FakeCA.NewCert. Multiple seconds per new host; kept for clients that
+	// require RSA-only leaf certs.
+	RSA
+)
+
+const (
+	// defaultCacheSize bounds the number of distinct SAN sets a CertStore caches.
+	defaultCacheSize = 256
+	// defaultTTL is how long a cached leaf cert is reused before being reissued.
+	defaultTTL = 24 * time.Hour
+	// defaultValidity is the NotBefore..NotAfter window stamped on issued leaf certs.
+	defaultValidity = 90 * 24 * time.Hour
+)
+
+// CertConfig configures a CertStore's cache size, entry TTL, key type and validity window.
+type CertConfig struct {
+	// KeyType selects the leaf key algorithm. Defaults to ECDSA.
+	KeyType KeyType
+	// CacheSize bounds the number of distinct SAN sets cached. Defaults to 256.
+	CacheSize int
+	// TTL is how long a cached leaf cert is reused before being reissued. Defaults to 24h.
+	TTL time.Duration
+	// Validity is the NotBefore..NotAfter window stamped on issued leaf certs. Defaults
+	// to 90 days.
+	Validity time.Duration
+}
+
+func (c CertConfig) withDefaults() CertConfig {
+	if c.CacheSize <= 0 {
+		c.CacheSize = defaultCacheSize
+	}
+	if c.TTL <= 0 {
+		c.TTL = defaultTTL
+	}
+	if c.Validity <= 0 {
+		c.Validity = defaultValidity
+	}
+	return c
+}
+
+// certEntry is one LRU-cached, TTL-bounded leaf certificate.
+type certEntry struct {
+	key        string
+	certPEM    []byte
+	privKeyPEM []byte
+	expires    time.Time
+}
+
+// CertStore issues per-host leaf certificates signed by a FakeCA and caches them in a
+// size-bounded LRU keyed by the sorted set of requested SANs, so repeated requests for
+// the same host reuse the same certificate instead of paying for a fresh keypair and
+// signature on every CONNECT.
+type CertStore struct {
+	ca  *FakeCA
+	cfg CertConfig
+
+	// authorityKeyId is sha1(caPubDER), used as both SubjectKeyId and AuthorityKeyId on
+	// every leaf cert template so that key identification is deterministic across
+	// restarts. x509.CreateCertificate replaces the template's AuthorityKeyId with the
+	// CA certificate's own SubjectKeyId whenever the CA certificate carries one.
+	authorityKeyId []byte
+
+	// leafKey is the single ECDSA P-256 key reused across every leaf cert when
+	// cfg.KeyType == ECDSA. Unused when cfg.KeyType == RSA.
+	leafKey *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most-recently-used at the front
+}
+
+// NewCertStore returns a CertStore backed by ca. It generates the shared ECDSA leaf key
+// (if cfg.KeyType is ECDSA) up front, so that cost is paid once at startup rather than
+// on the first request to every new host.
+func NewCertStore(ca *FakeCA, cfg CertConfig) (*CertStore, error) {
+	cfg = cfg.withDefaults()
+
+	caPubDER, err := x509.MarshalPKIXPublicKey(&ca.PrivKey.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal CA public key: %w", err)
+	}
+	skid := sha1.Sum(caPubDER)
+
+	cs := &CertStore{
+		ca:             ca,
+		cfg:            cfg,
+		authorityKeyId: skid[:],
+		entries:        make(map[string]*list.Element),
+		order:          list.New(),
+	}
+
+	if cfg.KeyType == ECDSA {
+		leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate leaf key: %w", err)
+		}
+		cs.leafKey = leafKey
+	}
+
+	return cs, nil
+}
+
+// Cert returns a PEM-encoded leaf certificate and private key valid for dnsNames,
+// reusing a cached cert if one for the same SAN set hasn't expired.
+func (cs *CertStore) Cert(dnsNames []string) (certPEM, privKeyPEM []byte, err error) {
+	key := cacheKey(dnsNames)
+
+	cs.mu.Lock()
+	if el, ok := cs.entries[key]; ok {
+		entry := el.Value.(*certEntry)
+		if time.Now().Before(entry.expires) {
+			cs.order.MoveToFront(el)
+			cs.mu.Unlock()
+			return entry.certPEM, entry.privKeyPEM, nil
+		}
+		cs.order.Remove(el)
+		delete(cs.entries, key)
+	}
+	cs.mu.Unlock()
+
+	certPEM, privKeyPEM, err = cs.issue(dnsNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.insertLocked(&certEntry{
+		key:        key,
+		certPEM:    certPEM,
+		privKeyPEM: privKeyPEM,
+		expires:    time.Now().Add(cs.cfg.TTL),
+	})
+
+	return certPEM, privKeyPEM, nil
+}
+
+// Flush drops every cached leaf certificate, forcing reissuance on next use.
+func (cs *CertStore) Flush() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.entries = make(map[string]*list.Element)
+	cs.order = list.New()
+}
+
+func (cs *CertStore) insertLocked(entry *certEntry) {
+	// A concurrent caller may have raced us to issue a cert for the same key; the last
+	// one to land wins the cache slot, which is harmless since both are valid certs.
+	if el, ok := cs.entries[entry.key]; ok {
+		cs.order.Remove(el)
+	}
+	cs.entries[entry.key] = cs.order.PushFront(entry)
+
+	for cs.order.Len() > cs.cfg.CacheSize {
+		oldest := cs.order.Back()
+		if oldest == nil {
+			break
+		}
+		cs.order.Remove(oldest)
+		delete(cs.entries, oldest.Value.(*certEntry).key)
+	}
+}
+
+func (cs *CertStore) issue(dnsNames []string) (certPEM, privKeyPEM []byte, err error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate serial: %w", err)
+	}
+
+	names, ips := splitHostNames(dnsNames)
+
+	cert := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        newName(),
+		DNSNames:       names,
+		IPAddresses:    ips,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(cs.cfg.Validity),
+		SubjectKeyId:   cs.authorityKeyId,
+		AuthorityKeyId: cs.authorityKeyId,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}
+
+	leafKey, err := cs.leafPrivateKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("leaf key: %w", err)
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, cs.ca.Cert, leafKey.Public(), cs.ca.PrivKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create certificate: %w", err)
+	}
+
+	keyDER, keyType, err := marshalPrivateKey(leafKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBuf := new(bytes.Buffer)
+	if err := pem.Encode(keyBuf, &pem.Block{Type: keyType, Bytes: keyDER}); err != nil {
+		return nil, nil, err
+	}
+
+	certBuf := new(bytes.Buffer)
+	if err := pem.Encode(certBuf, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes}); err != nil {
+		return nil, nil, err
+	}
+
+	return certBuf.Bytes(), keyBuf.Bytes(), nil
+}
+
+// leafPrivateKey returns the key to sign the next leaf cert with: the single shared
+// ECDSA key, or a freshly generated RSA-4096 key, depending on cs.cfg.KeyType.
+func (cs *CertStore) leafPrivateKey() (crypto.Signer, error) {
+	if cs.cfg.KeyType == RSA {
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+	return cs.leafKey, nil
+}
+
+func marshalPrivateKey(key crypto.Signer) (der []byte, pemType string, err error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err = x509.MarshalECPrivateKey(k)
+		return der, "EC PRIVATE KEY", err
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(k), "RSA PRIVATE KEY", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported leaf key type %T", key)
+	}
+}
+
+// cacheKey returns a stable key for a SAN set, independent of the order names were
+// requested in.
+func cacheKey(dnsNames []string) string {
+	sorted := append([]string(nil), dnsNames...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// splitHostNames separates dnsNames into DNS names and IP addresses, since
+// x509.Certificate rejects IP literals in DNSNames.
+func splitHostNames(dnsNames []string) (names []string, ips []net.IP) {
+	for _, n := range dnsNames {
+		if ip := net.ParseIP(n); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		names = append(names, n)
+	}
+	return names, ips
+}
+
+// randomSerial returns a random serial number in [1, 2^160-1], as recommended by the
+// CA/Browser Forum baseline requirements for unpredictable serials.
+func randomSerial() (*big.Int, error) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 160), big.NewInt(1))
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return nil, err
+	}
+	return n.Add(n, big.NewInt(1)), nil
+}
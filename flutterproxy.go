@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,6 +14,10 @@ import (
 
 	"github.com/thinkassembly/flutterproxy/connectproxy"
 	"github.com/thinkassembly/flutterproxy/fakeca"
+	"github.com/thinkassembly/flutterproxy/proxyproto"
+	"github.com/thinkassembly/flutterproxy/tofu"
+	"github.com/thinkassembly/flutterproxy/transcoder"
+	"github.com/thinkassembly/flutterproxy/transcript"
 )
 
 type strList []string
@@ -114,10 +119,42 @@ func run() {
 	var prefixPairs strList
 	runCmd.Var(&prefixPairs, "prefix_pair", "List of path prefixes to forward: '<host>,<path>'")
 
+	var certCacheSize = runCmd.Int("cert_cache_size", 0, "Max number of per-host leaf certs to cache (0 = default)")
+	var certTTL = runCmd.Duration("cert_ttl", 0, "How long a cached leaf cert is reused before reissuance (0 = default)")
+	var certValidity = runCmd.Duration("cert_validity", 0, "NotBefore..NotAfter window stamped on issued leaf certs (0 = default)")
+	var certKeyType = runCmd.String("cert_key_type", "ecdsa", "Leaf certificate key algorithm: 'ecdsa' or 'rsa'")
+
+	var adminHost = runCmd.String("admin_host", "", "Host header that routes a request on -addr to the admin endpoints (/ca.crt, /ca.der, /status, /flush, /tofu/reset)")
+	var adminAddr = runCmd.String("admin_addr", "", "If set, also serve the admin endpoints on this separate address")
+
+	var record = runCmd.String("record", "", "Record traffic to a transcript file: 'har:<path>' or 'jsonl:<path>'")
+	var recordBodyCap = runCmd.Int64("record_body_cap", 0, "Max bytes of a request/response body to keep in a recorded entry (0 = default)")
+
+	var proxyProtocolPairs strList
+	runCmd.Var(&proxyProtocolPairs, "proxy_protocol", "List of outbound PROXY protocol pairs: '<host:port>,<version>' where version is 'v1' or 'v2'")
+	var proxyProtocolListen = runCmd.Bool("proxy_protocol_listen", false, "Expect a PROXY protocol v1/v2 header on every inbound connection to -addr")
+
+	var transcoders strList
+	runCmd.Var(&transcoders, "transcoder", "List of response transcoders: '<content-type-or-encoding>,<name>', where name is 'gzip', 'jsonpretty', or 'sourcemap:<url>'. Brotli ('br') responses are not supported: the repo carries no brotli decoder, and registering 'br' is rejected rather than silently passed through compressed")
+
+	var tofuStorePath = runCmd.String("tofu_store", "", "Path to a JSON file pinning upstream TLS certs by 'host:port' (trust-on-first-use); empty disables pinning")
+	var tofuReset strList
+	runCmd.Var(&tofuReset, "tofu_reset", "Drop a pinned upstream cert for 'host:port' before starting (repeatable)")
+
 	if err := runCmd.Parse(os.Args[2:]); err != nil {
 		log.Fatalf("Parse flags error: %v", err)
 	}
 
+	var keyType fakeca.KeyType
+	switch strings.ToLower(*certKeyType) {
+	case "", "ecdsa":
+		keyType = fakeca.ECDSA
+	case "rsa":
+		keyType = fakeca.RSA
+	default:
+		log.Fatalf("Invalid -cert_key_type %q, want 'ecdsa' or 'rsa'", *certKeyType)
+	}
+
 	keyPEM, err := os.ReadFile(*key)
 	if err != nil {
 		log.Fatalf("Read CA private key error: %v", err)
@@ -133,20 +170,77 @@ func run() {
 		log.Fatalf("Load CA error: %v", err)
 	}
 
+	recorder, recordFile, err := newRecorder(*record)
+	if err != nil {
+		log.Fatalf("Open transcript recorder error: %v", err)
+	}
+
+	transcoderOpts, err := newTranscoderOptions(transcoders)
+	if err != nil {
+		log.Fatalf("Parse -transcoder error: %v", err)
+	}
+
+	var tofuStore *tofu.Store
+	if *tofuStorePath != "" {
+		tofuStore, err = tofu.NewStore(*tofuStorePath)
+		if err != nil {
+			log.Fatalf("Open TOFU store error: %v", err)
+		}
+		for _, hostPort := range tofuReset {
+			if err := tofuStore.Reset(hostPort); err != nil {
+				log.Fatalf("Reset TOFU pin for %s error: %v", hostPort, err)
+			}
+		}
+	}
+
 	ctx := context.Background()
 	done := make(chan bool)
 
-	proxy := connectproxy.New(fakeCA, hostPairs, prefixPairs, done)
+	opts := []connectproxy.Option{
+		connectproxy.WithCertCacheSize(*certCacheSize),
+		connectproxy.WithCertTTL(*certTTL),
+		connectproxy.WithCertValidity(*certValidity),
+		connectproxy.WithCertKeyType(keyType),
+		connectproxy.WithAdminHost(*adminHost),
+		connectproxy.WithRecorder(recorder),
+		connectproxy.WithRecordBodyCap(*recordBodyCap),
+		connectproxy.WithProxyProtocol(proxyProtocolPairs),
+	}
+	opts = append(opts, transcoderOpts...)
+	if tofuStore != nil {
+		opts = append(opts, connectproxy.WithTOFUStore(tofuStore))
+	}
+
+	proxy := connectproxy.New(fakeCA, hostPairs, prefixPairs, done, opts...)
 
 	log.Println("Starting proxy server at", *addr)
 
-	server := &http.Server{Addr: *addr, Handler: proxy}
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal("Listen:", err)
+	}
+	if *proxyProtocolListen {
+		listener = proxyproto.Listen(listener)
+	}
+
+	server := &http.Server{Handler: proxy}
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			log.Print("ListenAndServe:", err)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Print("Serve:", err)
 		}
 	}()
 
+	var adminServer *http.Server
+	if *adminAddr != "" {
+		log.Println("Starting admin server at", *adminAddr)
+		adminServer = &http.Server{Addr: *adminAddr, Handler: proxy.AdminHandler()}
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil {
+				log.Print("Admin ListenAndServe:", err)
+			}
+		}()
+	}
+
 	select {
 	case <-done:
 	case <-ctx.Done():
@@ -155,4 +249,76 @@ func run() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatal("Shutdown:", err)
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Fatal("Admin shutdown:", err)
+		}
+	}
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			log.Print("Close transcript recorder:", err)
+		}
+		if err := recordFile.Close(); err != nil {
+			log.Print("Close transcript file:", err)
+		}
+	}
+}
+
+// newRecorder parses a --record flag value of the form "har:<path>" or
+// "jsonl:<path>" and opens the corresponding transcript.Recorder. It returns a nil
+// Recorder and nil file if spec is empty.
+func newRecorder(spec string) (transcript.Recorder, *os.File, error) {
+	if spec == "" {
+		return nil, nil, nil
+	}
+
+	format, path, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid -record %q, want 'har:<path>' or 'jsonl:<path>'", spec)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch format {
+	case "har":
+		return transcript.NewHAR(f), f, nil
+	case "jsonl":
+		return transcript.NewJSONL(f), f, nil
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("invalid -record format %q, want 'har' or 'jsonl'", format)
+	}
+}
+
+// newTranscoderOptions parses repeated --transcoder flags of the form
+// "<content-type-or-encoding>,<name>" into connectproxy.Option values.
+func newTranscoderOptions(specs []string) ([]connectproxy.Option, error) {
+	var opts []connectproxy.Option
+	for _, spec := range specs {
+		mimeType, name, ok := strings.Cut(spec, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid -transcoder %q, want '<content-type>,<name>'", spec)
+		}
+		if mimeType == "br" {
+			return nil, fmt.Errorf("invalid -transcoder %q: brotli ('br') is not supported, the repo carries no brotli decoder", spec)
+		}
+
+		var t transcoder.Transcoder
+		switch {
+		case name == "gzip":
+			t = transcoder.NewGzipRecompressor()
+		case name == "jsonpretty":
+			t = transcoder.NewJSONPrettyPrinter()
+		case strings.HasPrefix(name, "sourcemap:"):
+			t = transcoder.NewSourceMapInjector(strings.TrimPrefix(name, "sourcemap:"))
+		default:
+			return nil, fmt.Errorf("invalid -transcoder name %q, want 'gzip', 'jsonpretty', or 'sourcemap:<url>'", name)
+		}
+
+		opts = append(opts, connectproxy.WithTranscoder(mimeType, t))
+	}
+	return opts, nil
 }
@@ -0,0 +1,50 @@
+package httpsproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/thinkassembly/flutterproxy/proxyproto"
+)
+
+type clientAddrKeyType struct{}
+
+var clientAddrKey clientAddrKeyType
+
+// withClientAddr stashes r.RemoteAddr into the request context so a later
+// proxyProtocolDialContext call, writing an outbound PROXY protocol header to the real
+// upstream, can recover the address of whoever dialed this Proxy's local TLS listener.
+// When this Proxy's local listener was itself started with proxyproto.Listen (see
+// StartServer), that address is the original CONNECT client, not connectproxy's
+// loopback dial.
+func withClientAddr(r *http.Request) {
+	ctx := context.WithValue(r.Context(), clientAddrKey, r.RemoteAddr)
+	*r = *r.WithContext(ctx)
+}
+
+// proxyProtocolDialContext returns a net.Dialer.DialContext-shaped func that dials addr
+// as usual, then writes a PROXY protocol header of the given version to the new
+// connection before anything else (in particular, before the TLS handshake the caller's
+// http.Transport performs on top of it) describing the client address withClientAddr
+// recorded and the dialed destination.
+func proxyProtocolDialContext(version string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		src, _ := ctx.Value(clientAddrKey).(string)
+		if src == "" {
+			src = conn.LocalAddr().String()
+		}
+
+		if err := proxyproto.WriteHeader(conn, version, src, conn.RemoteAddr().String()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,164 @@
+package httpsproxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/thinkassembly/flutterproxy/transcript"
+)
+
+type captureKeyType struct{}
+
+var captureKey captureKeyType
+
+// captureState carries everything startCapture gathers about a request through to
+// modifyResponse via the request's context, since httputil.ReverseProxy gives us no
+// other place to thread it.
+type captureState struct {
+	originalHost string
+
+	reqBody *capturedBody
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	firstByte    time.Time
+}
+
+// startCapture records the pre-rewrite Host, begins teeing the request body into a
+// capped buffer, and attaches an httptrace.ClientTrace that fills in DNS/connect/TTFB
+// timings as the RoundTrip progresses. It must run before the director rewrites r.Host.
+func (hp *Proxy) startCapture(r *http.Request) {
+	state := &captureState{originalHost: r.Host, start: time.Now()}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		state.reqBody = newCapturedBody(r.Body, hp.maxBodyBytes)
+		r.Body = state.reqBody
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { state.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { state.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { state.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { state.connectDone = time.Now() },
+		GotFirstResponseByte: func() { state.firstByte = time.Now() },
+	}
+	ctx := context.WithValue(r.Context(), captureKey, state)
+	ctx = httptrace.WithClientTrace(ctx, trace)
+	*r = *r.WithContext(ctx)
+}
+
+func (s *captureState) timings() transcript.Timings {
+	t := transcript.Timings{Total: time.Since(s.start)}
+	if !s.dnsDone.IsZero() {
+		t.DNS = s.dnsDone.Sub(s.dnsStart)
+	}
+	if !s.connectDone.IsZero() {
+		t.Connect = s.connectDone.Sub(s.connectStart)
+	}
+	if !s.firstByte.IsZero() {
+		t.TTFB = s.firstByte.Sub(s.start)
+	}
+	return t
+}
+
+// capturedBody tees up to maxBytes of a request body into an in-memory buffer as it is
+// read by the upstream RoundTrip, while still passing every byte through to the real
+// reader untouched.
+type capturedBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	remaining int64
+	truncated bool
+}
+
+func newCapturedBody(rc io.ReadCloser, maxBytes int64) *capturedBody {
+	return &capturedBody{ReadCloser: rc, remaining: maxBytes}
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if int64(n) <= b.remaining {
+			b.buf.Write(p[:n])
+			b.remaining -= int64(n)
+		} else if b.remaining > 0 {
+			b.buf.Write(p[:b.remaining])
+			b.remaining = 0
+			b.truncated = true
+		} else {
+			b.truncated = true
+		}
+	}
+	return n, err
+}
+
+// modifyResponse is installed as httputil.ReverseProxy.ModifyResponse on both the
+// target and next proxies. It first runs any registered transcoders over the body, then
+// reads the (possibly rewritten) body so it can both pass it through to the client
+// unchanged and hand a capped copy to the transcript recorder.
+func (hp *Proxy) modifyResponse(resp *http.Response) error {
+	if err := hp.applyTranscoders(resp); err != nil {
+		return err
+	}
+
+	if hp.recorder == nil {
+		return nil
+	}
+	state, _ := resp.Request.Context().Value(captureKey).(*captureState)
+	if state == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recordedBody := body
+	truncated := false
+	if int64(len(body)) > hp.maxBodyBytes {
+		recordedBody = body[:hp.maxBodyBytes]
+		truncated = true
+	}
+
+	var reqBody []byte
+	reqTruncated := false
+	if state.reqBody != nil {
+		reqBody = state.reqBody.buf.Bytes()
+		reqTruncated = state.reqBody.truncated
+	}
+
+	entry := transcript.Entry{
+		StartedAt:             state.start,
+		Method:                resp.Request.Method,
+		URL:                   resp.Request.URL.String(),
+		OriginalHost:          state.originalHost,
+		TargetHost:            resp.Request.Host,
+		RequestHeader:         resp.Request.Header,
+		RequestBody:           reqBody,
+		RequestBodyTruncated:  reqTruncated,
+		ResponseStatus:        resp.StatusCode,
+		ResponseHeader:        resp.Header,
+		ResponseBody:          recordedBody,
+		ResponseBodyTruncated: truncated,
+		Timings:               state.timings(),
+	}
+
+	go func() {
+		if err := hp.recorder.Record(entry); err != nil {
+			log.Printf("transcript: record entry for %s: %v", entry.URL, err)
+		}
+	}()
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package httpsproxy
 
 import (
+	"crypto/ecdsa"
 	"crypto/tls"
 	"fmt"
 	"log"
@@ -9,8 +10,17 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+
+	"github.com/thinkassembly/flutterproxy/proxyproto"
+	"github.com/thinkassembly/flutterproxy/tofu"
+	"github.com/thinkassembly/flutterproxy/transcoder"
+	"github.com/thinkassembly/flutterproxy/transcript"
 )
 
+// defaultMaxRecordedBodyBytes bounds how much of a request/response body is kept in a
+// transcript entry when maxBodyBytes isn't set.
+const defaultMaxRecordedBodyBytes = 1 << 20 // 1 MiB
+
 // Proxy is a HTTPS reverse proxy. It is a man-in-the-middle proxy that intercepts and redirects
 // requests to a HTTP host. It can optional forward the requests that match a list of path
 // prefixes to the real HTTPS server.
@@ -24,24 +34,71 @@ type Proxy struct {
 	nextHost string
 
 	prefixes []string
+
+	recorder     transcript.Recorder
+	maxBodyBytes int64
+
+	transcoders map[string]transcoder.Transcoder
+
+	tofuStore *tofu.Store
+
+	// proxyProtoVersion, if non-empty, is the PROXY protocol version ("v1" or "v2")
+	// this Proxy expects on its own local listener (connectproxy relays the original
+	// CONNECT client's address over that hop) and re-emits, describing that same
+	// client, when it dials the real upstream for a next-forwarded request.
+	proxyProtoVersion string
+}
+
+// cipherSuitesFor returns the TLS 1.2 cipher suites to offer for cert's leaf key
+// algorithm: an ECDSA leaf (fakeca's default, see fakeca.KeyType) needs ECDSA-auth
+// suites, not the RSA-auth ones, or a TLS-1.2-only client gets a handshake failure with
+// no mutually supported suite. TLS 1.3 picks its own suites and ignores this list.
+func cipherSuitesFor(cert tls.Certificate) []uint16 {
+	if _, ok := cert.PrivateKey.(*ecdsa.PrivateKey); ok {
+		return []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		}
+	}
+	return []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	}
 }
 
 // New returns a new HTTPS reverse proxy to a HTTP host, with an list of path prefixes that
-// should be forwarded to the nextHost.
-func New(cert tls.Certificate, targetHost, nextHost string, prefixes []string) (*Proxy, error) {
+// should be forwarded to the nextHost. If recorder is non-nil, every exchange is recorded
+// to it, with request/response bodies capped at maxBodyBytes (0 uses a 1 MiB default).
+// transcoders rewrite a response body before it reaches the client (and the recorder),
+// keyed by the Content-Encoding or Content-Type they match; it may be nil. If tofuStore is
+// non-nil, the TLS connection this proxy dials to nextHost is pinned to the upstream's
+// SubjectPublicKeyInfo on first use. If proxyProtoVersion is non-empty, see the Proxy
+// field doc above.
+func New(cert tls.Certificate, targetHost, nextHost string, prefixes []string, recorder transcript.Recorder, maxBodyBytes int64, transcoders map[string]transcoder.Transcoder, tofuStore *tofu.Store, proxyProtoVersion string) (*Proxy, error) {
 	cfg := &tls.Config{
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-		},
-		Certificates: []tls.Certificate{cert},
+		CipherSuites:             cipherSuitesFor(cert),
+		Certificates:             []tls.Certificate{cert},
+	}
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxRecordedBodyBytes
 	}
-	return &Proxy{cfg: cfg, targetHost: targetHost, nextHost: nextHost, prefixes: prefixes}, nil
+	return &Proxy{
+		cfg:          cfg,
+		targetHost:   targetHost,
+		nextHost:     nextHost,
+		prefixes:     prefixes,
+		recorder:     recorder,
+		maxBodyBytes: maxBodyBytes,
+		transcoders:  transcoders,
+		tofuStore:    tofuStore,
+
+		proxyProtoVersion: proxyProtoVersion,
+	}, nil
 }
 
 // StartServer starts a HTTPS server for the reverse proxy.
@@ -50,6 +107,11 @@ func (hp *Proxy) StartServer() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if hp.proxyProtoVersion != "" {
+		// connectproxy relays the original CONNECT client's address to us over this
+		// hop with a leading PROXY protocol header instead of a raw TLS ClientHello.
+		listener = proxyproto.Listen(listener)
+	}
 
 	targetURL, err := url.Parse("http://" + hp.targetHost)
 	if err != nil {
@@ -62,11 +124,28 @@ func (hp *Proxy) StartServer() (string, error) {
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-	proxy.Director = makeDirector(proxy.Director, nextURL.Host)
+	proxy.Director = hp.wrapDirector(proxy.Director, nextURL.Host)
+	proxy.ModifyResponse = hp.modifyResponse
 	hp.target = proxy
 
 	nextProxy := httputil.NewSingleHostReverseProxy(nextURL)
-	nextProxy.Director = makeDirector(nextProxy.Director, nextURL.Host)
+	nextProxy.Director = hp.wrapDirector(nextProxy.Director, nextURL.Host)
+	nextProxy.ModifyResponse = hp.modifyResponse
+	if hp.tofuStore != nil || hp.proxyProtoVersion != "" {
+		transport := &http.Transport{}
+		if hp.tofuStore != nil {
+			transport.TLSClientConfig = &tls.Config{
+				VerifyPeerCertificate: hp.tofuStore.Verifier(nextURL.Host),
+			}
+		}
+		if hp.proxyProtoVersion != "" {
+			// One PROXY header describes one client; don't let the transport hand a
+			// pooled connection carrying a stale header to a different client's request.
+			transport.DisableKeepAlives = true
+			transport.DialContext = proxyProtocolDialContext(hp.proxyProtoVersion)
+		}
+		nextProxy.Transport = transport
+	}
 	hp.next = nextProxy
 
 	port := listener.Addr().(*net.TCPAddr).Port
@@ -84,6 +163,25 @@ func (hp *Proxy) StartServer() (string, error) {
 	return addr, nil
 }
 
+// wrapDirector wraps makeDirector with request-body capture and timing instrumentation
+// for the transcript recorder, and client-address propagation for an outbound PROXY
+// protocol header, whichever of those are configured.
+func (hp *Proxy) wrapDirector(director func(*http.Request), host string) func(*http.Request) {
+	base := makeDirector(director, host)
+	if hp.recorder == nil && hp.proxyProtoVersion == "" {
+		return base
+	}
+	return func(r *http.Request) {
+		if hp.proxyProtoVersion != "" {
+			withClientAddr(r)
+		}
+		if hp.recorder != nil {
+			hp.startCapture(r)
+		}
+		base(r)
+	}
+}
+
 // makeDirector a modified version of httputil.Director that sets http.Request.Host.
 // The default httputil.NewSingleHostReverseProxy director ends in a loop.
 func makeDirector(director func(*http.Request), host string) func(*http.Request) {
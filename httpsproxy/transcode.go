@@ -0,0 +1,49 @@
+package httpsproxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// applyTranscoders rewrites resp.Body in place using any transcoder registered for the
+// response's Content-Encoding, then its Content-Type, so a Content-Type transcoder (and
+// the transcript recorder) sees already-decompressed bytes.
+func (hp *Proxy) applyTranscoders(resp *http.Response) error {
+	if len(hp.transcoders) == 0 {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		if t, ok := hp.transcoders[enc]; ok {
+			var out bytes.Buffer
+			if err := t.Transcode(enc, bytes.NewReader(body), &out); err != nil {
+				return err
+			}
+			body = out.Bytes()
+			resp.Header.Del("Content-Encoding")
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if t, ok := hp.transcoders[mediaType]; ok {
+		var out bytes.Buffer
+		if err := t.Transcode(mediaType, bytes.NewReader(body), &out); err != nil {
+			return err
+		}
+		body = out.Bytes()
+	}
+
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
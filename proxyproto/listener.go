@@ -0,0 +1,158 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Listen wraps inner so that every accepted connection is peeked for a leading PROXY
+// v1 or v2 header. If one is present, it is consumed and RemoteAddr reports the
+// client address it describes instead of the immediate TCP peer (which, behind an L4
+// load balancer, is the balancer itself). Connections without a header are passed
+// through unchanged.
+func Listen(inner net.Listener) net.Listener {
+	return &listener{inner}
+}
+
+type listener struct {
+	net.Listener
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: c, br: bufio.NewReaderSize(c, 256)}, nil
+}
+
+// conn overrides Read (to replay any bytes buffered while peeking, and to consume a
+// leading header the first time it's read) and RemoteAddr (when a PROXY header supplied
+// one). The header is peeked lazily, on first use, rather than in Accept: Accept is
+// called in a loop serialized against every other pending connection, so parsing it
+// there would let one slow or stalled client block every other connection from being
+// accepted.
+type conn struct {
+	net.Conn
+	br *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	headerErr  error
+}
+
+func (c *conn) peek() {
+	c.once.Do(func() {
+		c.remoteAddr, c.headerErr = peekHeader(c.br)
+	})
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.peek()
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+	return c.br.Read(p)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	c.peek()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func peekHeader(br *bufio.Reader) (net.Addr, error) {
+	peeked, err := br.Peek(len(v2Magic))
+	if err != nil && len(peeked) == 0 {
+		// Too little data to ever be a header (e.g. connection closed immediately);
+		// treat it as "no header" and let the caller's first real Read surface err.
+		return nil, nil
+	}
+
+	if len(peeked) >= len(v2Magic) && string(peeked) == v2Magic {
+		return parseV2(br)
+	}
+	if len(peeked) >= 6 && string(peeked[:6]) == "PROXY " {
+		return parseV1(br)
+	}
+	return nil, nil
+}
+
+func parseV1(br *bufio.Reader) (net.Addr, error) {
+	// A v1 header is a single line, at most 107 bytes including the trailing CRLF.
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(strings.TrimSpace(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errors.New("proxyproto: malformed v1 header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("proxyproto: malformed v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, errors.New("proxyproto: malformed v1 header")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func parseV2(br *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(v2Magic)+4)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+
+	verCmd := header[len(v2Magic)]
+	if verCmd>>4 != 2 {
+		return nil, errors.New("proxyproto: unsupported v2 header version")
+	}
+	cmd := verCmd & 0x0F
+
+	famProto := header[len(v2Magic)+1]
+	length := binary.BigEndian.Uint16(header[len(v2Magic)+2 : len(v2Magic)+4])
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+
+	if cmd == 0x0 {
+		// LOCAL command: health check from the proxy itself, no address to report.
+		return nil, nil
+	}
+
+	switch famProto >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("proxyproto: short v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("proxyproto: short v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		// AF_UNSPEC or an address family we don't report (e.g. AF_UNIX): no usable
+		// net.Addr to hand back.
+		return nil, nil
+	}
+}
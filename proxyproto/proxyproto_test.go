@@ -0,0 +1,145 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestWriteHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		src     string
+		dst     string
+	}{
+		{"v1 IPv4", V1, "203.0.113.7:51234", "198.51.100.9:443"},
+		{"v1 IPv6", V1, "[2001:db8::1]:51234", "[2001:db8::2]:443"},
+		{"v2 IPv4", V2, "203.0.113.7:51234", "198.51.100.9:443"},
+		{"v2 IPv6", V2, "[2001:db8::1]:51234", "[2001:db8::2]:443"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHeader(&buf, tc.version, tc.src, tc.dst); err != nil {
+				t.Fatalf("WriteHeader: %v", err)
+			}
+
+			br := bufio.NewReader(&buf)
+			addr, err := peekHeader(br)
+			if err != nil {
+				t.Fatalf("peekHeader: %v", err)
+			}
+
+			wantHost, wantPortStr, _ := net.SplitHostPort(tc.src)
+			tcpAddr, ok := addr.(*net.TCPAddr)
+			if !ok {
+				t.Fatalf("peekHeader returned %T, want *net.TCPAddr", addr)
+			}
+			if tcpAddr.IP.String() != net.ParseIP(wantHost).String() {
+				t.Errorf("IP = %v, want %v", tcpAddr.IP, wantHost)
+			}
+			if wantPort := mustAtoi(t, wantPortStr); tcpAddr.Port != wantPort {
+				t.Errorf("Port = %v, want %v", tcpAddr.Port, wantPort)
+			}
+		})
+	}
+}
+
+func TestWriteHeaderUnknownVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, "v3", "1.2.3.4:1", "1.2.3.5:2"); err == nil {
+		t.Fatal("expected error for unknown version")
+	}
+}
+
+func TestParseV1Malformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantErr bool
+	}{
+		{"unknown", "PROXY UNKNOWN\r\n", false},
+		{"too few fields", "PROXY TCP4 1.2.3.4\r\n", true},
+		{"bad prefix", "HELLO TCP4 1.2.3.4 1.2.3.5 1 2\r\n", true},
+		{"bad ip", "PROXY TCP4 notanip 1.2.3.5 1 2\r\n", true},
+		{"bad port", "PROXY TCP4 1.2.3.4 1.2.3.5 notaport 2\r\n", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			br := bufio.NewReader(bytes.NewBufferString(tc.line))
+			_, err := parseV1(br)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("parseV1(%q) error = %v, wantErr %v", tc.line, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseV2Malformed(t *testing.T) {
+	valid := make([]byte, 0)
+	valid = append(valid, v2Magic...)
+	valid = append(valid, 0x21, 0x11, 0x00, 0x0c)
+	valid = append(valid, net.ParseIP("203.0.113.7").To4()...)
+	valid = append(valid, net.ParseIP("198.51.100.9").To4()...)
+	valid = append(valid, 0xc0, 0x12, 0x01, 0xbb)
+
+	t.Run("valid", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(valid))
+		addr, err := parseV2(br)
+		if err != nil {
+			t.Fatalf("parseV2: %v", err)
+		}
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok || tcpAddr.IP.String() != "203.0.113.7" || tcpAddr.Port != 0xc012 {
+			t.Errorf("parseV2 = %+v, want 203.0.113.7:49170", addr)
+		}
+	})
+
+	t.Run("local command", func(t *testing.T) {
+		local := make([]byte, 0)
+		local = append(local, v2Magic...)
+		local = append(local, 0x20, 0x00, 0x00, 0x00)
+		br := bufio.NewReader(bytes.NewReader(local))
+		addr, err := parseV2(br)
+		if err != nil || addr != nil {
+			t.Errorf("parseV2(LOCAL) = %v, %v, want nil, nil", addr, err)
+		}
+	})
+
+	t.Run("bad version", func(t *testing.T) {
+		bad := make([]byte, len(valid))
+		copy(bad, valid)
+		bad[len(v2Magic)] = 0x11 // version 1, not supported
+		br := bufio.NewReader(bytes.NewReader(bad))
+		if _, err := parseV2(br); err == nil {
+			t.Error("expected error for unsupported version")
+		}
+	})
+
+	t.Run("truncated address block", func(t *testing.T) {
+		short := make([]byte, 0)
+		short = append(short, v2Magic...)
+		short = append(short, 0x21, 0x11, 0x00, 0x04)
+		short = append(short, 0x01, 0x02, 0x03, 0x04)
+		br := bufio.NewReader(bytes.NewReader(short))
+		if _, err := parseV2(br); err == nil {
+			t.Error("expected error for short IPv4 address block")
+		}
+	})
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			t.Fatalf("mustAtoi(%q): not a number", s)
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
@@ -0,0 +1,114 @@
+// Package proxyproto writes and parses HAProxy PROXY protocol v1/v2 headers
+// (https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt), so that a true client
+// IP can survive a hop through flutterproxy in either direction: outbound, when
+// flutterproxy dials a backend that needs to see past it, and inbound, when
+// flutterproxy itself sits behind another L4 proxy.
+package proxyproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// V1 and V2 select the PROXY protocol version WriteHeader writes.
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+const v2Magic = "\r\n\r\n\x00\r\nQUIT\n"
+
+// WriteHeader writes a PROXY protocol header of the given version to w, describing a
+// connection from src to dst (both "host:port" strings, as returned by net.Addr.String).
+func WriteHeader(w io.Writer, version, src, dst string) error {
+	switch version {
+	case V1:
+		return writeV1(w, src, dst)
+	case V2:
+		return writeV2(w, src, dst)
+	default:
+		return fmt.Errorf("proxyproto: unknown version %q", version)
+	}
+}
+
+func writeV1(w io.Writer, src, dst string) error {
+	srcHost, srcPort, err := splitHostPort(src)
+	if err != nil {
+		return err
+	}
+	dstHost, dstPort, err := splitHostPort(dst)
+	if err != nil {
+		return err
+	}
+
+	family := "TCP4"
+	if net.ParseIP(srcHost).To4() == nil {
+		family = "TCP6"
+	}
+
+	_, err = fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", family, srcHost, dstHost, srcPort, dstPort)
+	return err
+}
+
+func writeV2(w io.Writer, src, dst string) error {
+	srcHost, srcPort, err := splitHostPort(src)
+	if err != nil {
+		return err
+	}
+	dstHost, dstPort, err := splitHostPort(dst)
+	if err != nil {
+		return err
+	}
+
+	srcIP := net.ParseIP(srcHost)
+	dstIP := net.ParseIP(dstHost)
+	if srcIP == nil || dstIP == nil {
+		return fmt.Errorf("proxyproto: invalid address %q / %q", src, dst)
+	}
+
+	var addrFamily byte
+	var srcBytes, dstBytes []byte
+	if ip4, dst4 := srcIP.To4(), dstIP.To4(); ip4 != nil && dst4 != nil {
+		addrFamily = 0x11 // AF_INET, TCP
+		srcBytes, dstBytes = ip4, dst4
+	} else {
+		addrFamily = 0x21 // AF_INET6, TCP
+		srcBytes, dstBytes = srcIP.To16(), dstIP.To16()
+	}
+
+	header := make([]byte, 0, len(v2Magic)+2+2+len(srcBytes)+len(dstBytes)+4)
+	header = append(header, v2Magic...)
+	header = append(header, 0x21) // version 2, command PROXY
+	header = append(header, addrFamily)
+
+	addrLen := len(srcBytes) + len(dstBytes) + 4
+	lengthBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lengthBuf, uint16(addrLen))
+	header = append(header, lengthBuf...)
+
+	header = append(header, srcBytes...)
+	header = append(header, dstBytes...)
+
+	portBuf := make([]byte, 4)
+	binary.BigEndian.PutUint16(portBuf[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(portBuf[2:4], uint16(dstPort))
+	header = append(header, portBuf...)
+
+	_, err = w.Write(header)
+	return err
+}
+
+func splitHostPort(addr string) (host string, port int, err error) {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	portNum, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("proxyproto: invalid port in %q: %w", addr, err)
+	}
+	return h, portNum, nil
+}
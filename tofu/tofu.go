@@ -0,0 +1,110 @@
+// Package tofu implements trust-on-first-use pinning of upstream TLS certificates, so
+// that a MITM dev proxy which terminates inbound TLS with its own fake CA can still
+// detect when the real upstream server's certificate changes.
+package tofu
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pin records the SubjectPublicKeyInfo hash pinned for a host on first use, and when a
+// matching certificate was first and last seen.
+type Pin struct {
+	SPKIHash  string    `json:"spki_hash"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Store persists pins for "host:port" keys to a JSON file.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	pins map[string]Pin
+}
+
+// NewStore loads pins from path, starting with an empty store if the file doesn't exist
+// yet; the file is created on the first pin.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, pins: make(map[string]Pin)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.pins); err != nil {
+		return nil, fmt.Errorf("tofu: parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Verifier returns a tls.Config.VerifyPeerCertificate callback that pins the upstream
+// leaf certificate's SPKI hash for hostPort on first use, and rejects the connection if
+// a later leaf certificate's SPKI hash doesn't match the pin. hostPort should be the
+// same "host:port" the connection was dialed to.
+func (s *Store) Verifier(hostPort string) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tofu: no peer certificate presented")
+		}
+
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("tofu: parse peer certificate: %w", err)
+		}
+
+		sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		return s.check(hostPort, fmt.Sprintf("%x", sum))
+	}
+}
+
+func (s *Store) check(hostPort, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	pin, ok := s.pins[hostPort]
+	if !ok {
+		s.pins[hostPort] = Pin{SPKIHash: hash, FirstSeen: now, LastSeen: now}
+		return s.saveLocked()
+	}
+
+	if pin.SPKIHash != hash {
+		return fmt.Errorf("tofu: %s presented a certificate pinned to %s, got %s; it may have legitimately rotated, or this may be an attack (drop the pin with -tofu_reset=%s, or the /tofu/reset admin endpoint, to re-pin)", hostPort, pin.SPKIHash, hash, hostPort)
+	}
+
+	pin.LastSeen = now
+	s.pins[hostPort] = pin
+	return s.saveLocked()
+}
+
+// Reset drops the pin for hostPort, if any, so the next connection re-pins on first use.
+func (s *Store) Reset(hostPort string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pins[hostPort]; !ok {
+		return nil
+	}
+	delete(s.pins, hostPort)
+	return s.saveLocked()
+}
+
+func (s *Store) saveLocked() error {
+	data, err := json.MarshalIndent(s.pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
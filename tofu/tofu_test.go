@@ -0,0 +1,142 @@
+package tofu
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func selfSignedLeaf(t *testing.T, commonName string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	return der
+}
+
+func TestVerifierPinsOnFirstUse(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	leaf := selfSignedLeaf(t, "upstream.example.com")
+	verify := store.Verifier("upstream.example.com:443")
+
+	if err := verify([][]byte{leaf}, nil); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := verify([][]byte{leaf}, nil); err != nil {
+		t.Fatalf("second use of same cert: %v", err)
+	}
+}
+
+func TestVerifierRejectsChangedCert(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	first := selfSignedLeaf(t, "upstream.example.com")
+	second := selfSignedLeaf(t, "upstream.example.com")
+	verify := store.Verifier("upstream.example.com:443")
+
+	if err := verify([][]byte{first}, nil); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := verify([][]byte{second}, nil); err == nil {
+		t.Fatal("expected rejection of a certificate with a different SPKI")
+	}
+}
+
+func TestVerifierNoPeerCert(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	verify := store.Verifier("upstream.example.com:443")
+	if err := verify(nil, nil); err == nil {
+		t.Fatal("expected error when no certificate is presented")
+	}
+}
+
+func TestResetAllowsRepin(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	first := selfSignedLeaf(t, "upstream.example.com")
+	second := selfSignedLeaf(t, "upstream.example.com")
+	verify := store.Verifier("upstream.example.com:443")
+
+	if err := verify([][]byte{first}, nil); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := store.Reset("upstream.example.com:443"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if err := verify([][]byte{second}, nil); err != nil {
+		t.Fatalf("re-pin after reset: %v", err)
+	}
+}
+
+func TestNewStorePersistsAcrossLoads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins.json")
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	leaf := selfSignedLeaf(t, "upstream.example.com")
+	if err := store.Verifier("upstream.example.com:443")([][]byte{leaf}, nil); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore (reload): %v", err)
+	}
+	if err := reloaded.Verifier("upstream.example.com:443")([][]byte{leaf}, nil); err != nil {
+		t.Fatalf("pin should have survived reload: %v", err)
+	}
+
+	other := selfSignedLeaf(t, "upstream.example.com")
+	if err := reloaded.Verifier("upstream.example.com:443")([][]byte{other}, nil); err == nil {
+		t.Fatal("expected reloaded store to still reject a different cert")
+	} else if !strings.Contains(err.Error(), "tofu_reset") {
+		t.Errorf("error message should mention -tofu_reset hint, got: %v", err)
+	}
+}
+
+func TestNewStoreMissingFile(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "does-not-exist.json"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if len(store.pins) != 0 {
+		t.Errorf("expected empty store, got %d pins", len(store.pins))
+	}
+}
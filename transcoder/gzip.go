@@ -0,0 +1,31 @@
+package transcoder
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// NewGzipRecompressor returns a Transcoder that decompresses a gzip-encoded response
+// body to plaintext, so that downstream consumers (the HAR/JSONL recorder, other
+// transcoders) see the same bytes a browser would render. Register it under "gzip",
+// matched against a response's Content-Encoding rather than its Content-Type.
+//
+// Brotli ("br") isn't supported: the Go standard library has no brotli decoder and this
+// repo intentionally carries no third-party dependencies.
+func NewGzipRecompressor() Transcoder {
+	return gzipRecompressor{}
+}
+
+type gzipRecompressor struct{}
+
+func (gzipRecompressor) Transcode(contentType string, in io.Reader, out io.Writer) error {
+	r, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("transcoder: gzip: %w", err)
+	}
+	defer r.Close()
+
+	_, err = io.Copy(out, r)
+	return err
+}
@@ -0,0 +1,32 @@
+package transcoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// NewJSONPrettyPrinter returns a Transcoder that reindents a JSON response body for
+// readability. Register it under "application/json".
+func NewJSONPrettyPrinter() Transcoder {
+	return jsonPrettyPrinter{}
+}
+
+type jsonPrettyPrinter struct{}
+
+func (jsonPrettyPrinter) Transcode(contentType string, in io.Reader, out io.Writer) error {
+	body, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		// Not valid (or not complete) JSON; pass it through rather than fail the response.
+		_, err := out.Write(body)
+		return err
+	}
+
+	_, err = out.Write(buf.Bytes())
+	return err
+}
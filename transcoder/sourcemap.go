@@ -0,0 +1,48 @@
+package transcoder
+
+import (
+	"bytes"
+	"io"
+)
+
+var sourceMappingURLPrefix = []byte("//# sourceMappingURL=")
+
+// NewSourceMapInjector returns a Transcoder that rewrites a trailing
+// "//# sourceMappingURL=..." comment in a JavaScript response to point at localURL
+// instead, so a locally regenerated source map (e.g. from a dev build) is used instead
+// of the one the upstream server shipped. Register it under "application/javascript" (or
+// "text/javascript", depending on what the upstream sends).
+func NewSourceMapInjector(localURL string) Transcoder {
+	return &sourceMapInjector{localURL: localURL}
+}
+
+type sourceMapInjector struct {
+	localURL string
+}
+
+func (s *sourceMapInjector) Transcode(contentType string, in io.Reader, out io.Writer) error {
+	body, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	idx := bytes.LastIndex(body, sourceMappingURLPrefix)
+	if idx < 0 {
+		_, err := out.Write(body)
+		return err
+	}
+
+	rest := body[idx+len(sourceMappingURLPrefix):]
+	lineEnd := bytes.IndexByte(rest, '\n')
+
+	rewritten := make([]byte, 0, len(body)+len(s.localURL))
+	rewritten = append(rewritten, body[:idx]...)
+	rewritten = append(rewritten, sourceMappingURLPrefix...)
+	rewritten = append(rewritten, s.localURL...)
+	if lineEnd >= 0 {
+		rewritten = append(rewritten, rest[lineEnd:]...)
+	}
+
+	_, err = out.Write(rewritten)
+	return err
+}
@@ -0,0 +1,14 @@
+// Package transcoder lets httpsproxy.Proxy rewrite an intercepted response body before
+// it reaches the client (and, if recording is enabled, before it reaches the
+// transcript recorder).
+package transcoder
+
+import "io"
+
+// Transcoder rewrites a response body from in to out. contentType is the value the
+// registration was matched against: a Content-Type media type (e.g. "application/json",
+// with any ";charset=..." parameters already stripped) or, for transcoders registered
+// against a Content-Encoding instead, the encoding token itself (e.g. "gzip").
+type Transcoder interface {
+	Transcode(contentType string, in io.Reader, out io.Writer) error
+}
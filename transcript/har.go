@@ -0,0 +1,177 @@
+package transcript
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NewHAR returns a Recorder that buffers entries in memory and writes them as a single
+// HAR 1.2 log (http://www.softwareishard.com/blog/har-12-spec/) to w when Close is
+// called.
+func NewHAR(w io.Writer) Recorder {
+	return &harRecorder{w: w}
+}
+
+type harRecorder struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+func (h *harRecorder) Record(e Entry) error {
+	entry := toHAREntry(e)
+
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+
+	return nil
+}
+
+func (h *harRecorder) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	doc := harDoc{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "flutterproxy", Version: "1.0"},
+		Entries: h.entries,
+	}}
+
+	enc := json.NewEncoder(h.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+type harDoc struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []nameValue  `json:"headers"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+	PostData    *harPostData `json:"postData,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string      `json:"mimeType"`
+	Text     string      `json:"text"`
+	Params   []nameValue `json:"params,omitempty"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []nameValue `json:"headers"`
+	Content     harContent  `json:"content"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func toHAREntry(e Entry) harEntry {
+	reqContentType := e.RequestHeader.Get("Content-Type")
+	reqText, _ := bodyText(e.RequestBody) // HAR postData has no encoding field.
+
+	var postData *harPostData
+	if len(e.RequestBody) > 0 {
+		postData = &harPostData{
+			MimeType: reqContentType,
+			Text:     reqText,
+			Params:   formParams(reqContentType, e.RequestBody),
+		}
+	}
+
+	respText, respEncoding := bodyText(e.ResponseBody)
+
+	return harEntry{
+		StartedDateTime: e.StartedAt.Format(time.RFC3339Nano),
+		Time:            msOrNeg1(e.Timings.Total),
+		Request: harRequest{
+			Method:      e.Method,
+			URL:         e.URL,
+			HTTPVersion: "HTTP/1.1",
+			Headers:     headerList(e.RequestHeader),
+			HeadersSize: -1,
+			BodySize:    len(e.RequestBody),
+			PostData:    postData,
+		},
+		Response: harResponse{
+			Status:      e.ResponseStatus,
+			StatusText:  http.StatusText(e.ResponseStatus),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     headerList(e.ResponseHeader),
+			HeadersSize: -1,
+			BodySize:    len(e.ResponseBody),
+			Content: harContent{
+				Size:     len(e.ResponseBody),
+				MimeType: e.ResponseHeader.Get("Content-Type"),
+				Text:     respText,
+				Encoding: respEncoding,
+			},
+		},
+		Timings: harTimings{
+			DNS:     msOrNeg1(e.Timings.DNS),
+			Connect: msOrNeg1(e.Timings.Connect),
+			Send:    0,
+			// e.Timings.TTFB is measured from the start of the request, like Total; DNS
+			// and Connect are each measured as their own phase's duration, not from the
+			// request start. Subtract those phase durations out of TTFB to get the
+			// remaining wait on a consistent origin, rather than subtracting a duration
+			// from a since-start value.
+			Wait:    msOrNeg1(e.Timings.TTFB - e.Timings.DNS - e.Timings.Connect),
+			Receive: msOrNeg1(e.Timings.Total - e.Timings.TTFB),
+		},
+	}
+}
+
+// msOrNeg1 converts d to milliseconds, or -1 ("not measured") per the HAR spec if d is
+// zero or negative.
+func msOrNeg1(d time.Duration) float64 {
+	if d <= 0 {
+		return -1
+	}
+	return float64(d.Microseconds()) / 1000.0
+}
@@ -0,0 +1,93 @@
+package transcript
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// NewJSONL returns a Recorder that writes one JSON object per line as each exchange
+// completes, so a running proxy's transcript can be tailed live instead of only being
+// available once the process exits (unlike NewHAR).
+func NewJSONL(w io.Writer) Recorder {
+	return &jsonlRecorder{w: w}
+}
+
+type jsonlRecorder struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+type jsonlEntry struct {
+	StartedAt    string `json:"started_at"`
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	OriginalHost string `json:"original_host"`
+	TargetHost   string `json:"target_host"`
+
+	RequestHeaders       []nameValue `json:"request_headers"`
+	RequestBody          string      `json:"request_body,omitempty"`
+	RequestBodyEncoding  string      `json:"request_body_encoding,omitempty"`
+	RequestBodyTruncated bool        `json:"request_body_truncated,omitempty"`
+	PostDataParams       []nameValue `json:"post_data_params,omitempty"`
+
+	ResponseStatus        int         `json:"response_status"`
+	ResponseHeaders       []nameValue `json:"response_headers"`
+	ResponseBody          string      `json:"response_body,omitempty"`
+	ResponseBodyEncoding  string      `json:"response_body_encoding,omitempty"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated,omitempty"`
+
+	TimingsMS jsonlTimings `json:"timings_ms"`
+}
+
+type jsonlTimings struct {
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	TTFB    float64 `json:"ttfb"`
+	Total   float64 `json:"total"`
+}
+
+func (j *jsonlRecorder) Record(e Entry) error {
+	reqText, reqEncoding := bodyText(e.RequestBody)
+	respText, respEncoding := bodyText(e.ResponseBody)
+
+	line := jsonlEntry{
+		StartedAt:             e.StartedAt.Format("2006-01-02T15:04:05.000000000Z07:00"),
+		Method:                e.Method,
+		URL:                   e.URL,
+		OriginalHost:          e.OriginalHost,
+		TargetHost:            e.TargetHost,
+		RequestHeaders:        headerList(e.RequestHeader),
+		RequestBody:           reqText,
+		RequestBodyEncoding:   reqEncoding,
+		RequestBodyTruncated:  e.RequestBodyTruncated,
+		PostDataParams:        formParams(e.RequestHeader.Get("Content-Type"), e.RequestBody),
+		ResponseStatus:        e.ResponseStatus,
+		ResponseHeaders:       headerList(e.ResponseHeader),
+		ResponseBody:          respText,
+		ResponseBodyEncoding:  respEncoding,
+		ResponseBodyTruncated: e.ResponseBodyTruncated,
+		TimingsMS: jsonlTimings{
+			DNS:     float64(e.Timings.DNS.Microseconds()) / 1000.0,
+			Connect: float64(e.Timings.Connect.Microseconds()) / 1000.0,
+			TTFB:    float64(e.Timings.TTFB.Microseconds()) / 1000.0,
+			Total:   float64(e.Timings.Total.Microseconds()) / 1000.0,
+		},
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(data)
+	return err
+}
+
+// Close is a no-op: NewJSONL doesn't buffer and doesn't own w.
+func (j *jsonlRecorder) Close() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package transcript records intercepted HTTP exchanges flowing through the MITM'd
+// httpsproxy.Proxy, so that a dev-time run of flutterproxy can be replayed or inspected
+// after the fact instead of only showing up as a one-line log.Printf.
+package transcript
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Entry is one complete request/response exchange captured by a Recorder.
+type Entry struct {
+	StartedAt time.Time
+
+	Method string
+	URL    string // the request URL as sent upstream, after rewriting
+
+	// OriginalHost is the Host header the client sent before httpsproxy rewrote it to
+	// target the local or next-hop host.
+	OriginalHost string
+	// TargetHost is the Host header actually sent upstream.
+	TargetHost string
+
+	RequestHeader         http.Header
+	RequestBody           []byte
+	RequestBodyTruncated  bool
+	ResponseStatus        int
+	ResponseHeader        http.Header
+	ResponseBody          []byte
+	ResponseBodyTruncated bool
+
+	Timings Timings
+}
+
+// Timings breaks down where time went for one exchange.
+type Timings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+// Recorder records completed proxy exchanges to a transcript, such as a HAR file or a
+// JSONL stream.
+type Recorder interface {
+	// Record is called once per completed exchange. Implementations must be safe to
+	// call from multiple goroutines.
+	Record(Entry) error
+	// Close flushes any buffered entries. It does not close the underlying writer.
+	Close() error
+}
+
+// bodyText returns body as a string for embedding in a transcript, base64-encoding it
+// (and reporting "base64" as the encoding) when it isn't valid UTF-8.
+func bodyText(body []byte) (text, encoding string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// formParams parses body as a postData.params list when contentType indicates a URL
+// form-encoded body, as the HAR spec expects for POST bodies of that kind.
+func formParams(contentType string, body []byte) []nameValue {
+	if !isFormContentType(contentType) {
+		return nil
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+	var params []nameValue
+	for name, vs := range values {
+		for _, v := range vs {
+			params = append(params, nameValue{Name: name, Value: v})
+		}
+	}
+	return params
+}
+
+func isFormContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(mediaType) == "application/x-www-form-urlencoded"
+}
+
+// nameValue is a generic header/param/query pair, shared by the HAR and JSONL encoders.
+type nameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func headerList(h http.Header) []nameValue {
+	var list []nameValue
+	for name, values := range h {
+		for _, v := range values {
+			list = append(list, nameValue{Name: name, Value: v})
+		}
+	}
+	return list
+}
@@ -0,0 +1,132 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyText(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         []byte
+		wantText     string
+		wantEncoding string
+	}{
+		{"empty", nil, "", ""},
+		{"utf8", []byte("hello world"), "hello world", ""},
+		{"binary", []byte{0xff, 0xfe, 0x00, 0x80}, "//4AgA==", "base64"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text, encoding := bodyText(tc.body)
+			if text != tc.wantText || encoding != tc.wantEncoding {
+				t.Errorf("bodyText(%v) = %q, %q; want %q, %q", tc.body, text, encoding, tc.wantText, tc.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestFormParams(t *testing.T) {
+	params := formParams("application/x-www-form-urlencoded; charset=utf-8", []byte("a=1&b=2"))
+	got := map[string]string{}
+	for _, p := range params {
+		got[p.Name] = p.Value
+	}
+	if got["a"] != "1" || got["b"] != "2" {
+		t.Errorf("formParams = %+v, want a=1 b=2", params)
+	}
+
+	if params := formParams("application/json", []byte(`{"a":1}`)); params != nil {
+		t.Errorf("formParams for non-form content type = %+v, want nil", params)
+	}
+}
+
+func exampleEntry() Entry {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	return Entry{
+		StartedAt:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Method:         "GET",
+		URL:            "https://example.com/path",
+		OriginalHost:   "example.com",
+		TargetHost:     "127.0.0.1:8443",
+		RequestHeader:  header,
+		ResponseStatus: 200,
+		ResponseHeader: header,
+		ResponseBody:   []byte("hello"),
+		Timings: Timings{
+			DNS:     1 * time.Millisecond,
+			Connect: 2 * time.Millisecond,
+			TTFB:    5 * time.Millisecond,
+			Total:   10 * time.Millisecond,
+		},
+	}
+}
+
+func TestHARRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewHAR(&buf)
+
+	if err := rec.Record(exampleEntry()); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var doc harDoc
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Unmarshal HAR output: %v\n%s", err, buf.String())
+	}
+	if doc.Log.Version != "1.2" {
+		t.Errorf("Log.Version = %q, want 1.2", doc.Log.Version)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatalf("len(Entries) = %d, want 1", len(doc.Log.Entries))
+	}
+	entry := doc.Log.Entries[0]
+	if entry.Request.Method != "GET" || entry.Request.URL != "https://example.com/path" {
+		t.Errorf("Request = %+v", entry.Request)
+	}
+	if entry.Response.Status != 200 || entry.Response.Content.Text != "hello" {
+		t.Errorf("Response = %+v", entry.Response)
+	}
+}
+
+func TestJSONLRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewJSONL(&buf)
+
+	if err := rec.Record(exampleEntry()); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := rec.Record(exampleEntry()); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+
+	var line jsonlEntry
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("Unmarshal JSONL line: %v\n%s", err, lines[0])
+	}
+	if line.Method != "GET" || line.OriginalHost != "example.com" || line.TargetHost != "127.0.0.1:8443" {
+		t.Errorf("line = %+v", line)
+	}
+	if line.ResponseBody != "hello" || line.ResponseBodyEncoding != "" {
+		t.Errorf("ResponseBody = %q, ResponseBodyEncoding = %q", line.ResponseBody, line.ResponseBodyEncoding)
+	}
+	if line.TimingsMS.Total != 10 {
+		t.Errorf("TimingsMS.Total = %v, want 10", line.TimingsMS.Total)
+	}
+}